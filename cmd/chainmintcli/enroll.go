@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/chainmint/core"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/acme"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// enroll flags, bound in enrollCmd and read by AutoTLS/renewal.
+var (
+	flagACMEDirectory  string
+	flagRenewBefore    time.Duration
+	flagBootstrapToken string
+	flagEABKeyID       string
+	flagDNSName        string
+)
+
+// enrollCmd provisions (or renews) the TLS client certificate corectl
+// presents to cored, via an ACME (RFC 8555) order against --acme-directory.
+// This replaces the old requirement that operators drop tls.crt/tls.key
+// into $home out of band.
+func enrollCmd() *cobra.Command {
+	var revoke bool
+	cmd := &cobra.Command{
+		Use:   "enroll",
+		Short: "provision a TLS client certificate via ACME",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if revoke {
+				return acmeRevoke(context.Background())
+			}
+			return acmeEnroll(context.Background(), true)
+		},
+	}
+	cmd.Flags().StringVar(&flagACMEDirectory, "acme-directory", "https://ca.internal/acme/directory", "ACME directory URL (step-ca-compatible)")
+	cmd.Flags().DurationVar(&flagRenewBefore, "renew-before", 7*24*time.Hour, "renew if the cert expires within this long")
+	cmd.Flags().StringVar(&flagEABKeyID, "eab-key-id", "", "external account binding key ID issued by the CA, used instead of the http-01 challenge")
+	cmd.Flags().StringVar(&flagBootstrapToken, "bootstrap-token", "", "base64url-encoded external account binding MAC key, paired with --eab-key-id")
+	cmd.Flags().StringVar(&flagDNSName, "dns-name", "", "DNS name (CN) to request the certificate for (defaults to the host in --url)")
+	cmd.Flags().BoolVar(&revoke, "revoke", false, "revoke the current client certificate instead of provisioning one")
+	return cmd
+}
+
+// enrollDNSName returns the identifier to authorize with the ACME server:
+// --dns-name if set, otherwise the hostname from --url.
+func enrollDNSName() (string, error) {
+	if flagDNSName != "" {
+		return flagDNSName, nil
+	}
+	u, err := url.Parse(flagURL)
+	if err != nil {
+		return "", fmt.Errorf("acme: parsing --url to derive --dns-name: %w", err)
+	}
+	host := u.Hostname()
+	if host == "" {
+		return "", fmt.Errorf("acme: --dns-name is required (could not derive a hostname from --url %q)", flagURL)
+	}
+	return host, nil
+}
+
+// autoTLSConfig returns a *tls.Config for the client cert/key at
+// certFile/keyFile, enrolling or renewing it first via ACME if it is
+// missing or within flagRenewBefore of expiry.
+func autoTLSConfig(ctx context.Context, certFile, keyFile string) (*tls.Config, error) {
+	if needsEnrollment(certFile) {
+		if err := acmeEnroll(ctx, false); err != nil {
+			return nil, fmt.Errorf("auto-tls: %s", err)
+		}
+	}
+	config, err := core.TLSConfig(certFile, keyFile, flagCACert)
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func needsEnrollment(certFile string) bool {
+	pemBytes, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return true
+	}
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return true
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return true
+	}
+	return time.Until(cert.NotAfter) < flagRenewBefore
+}
+
+// acmeEnroll performs an ACME order against flagACMEDirectory and writes
+// the resulting keypair to $home/tls.crt and $home/tls.key. When
+// flagEABKeyID and flagBootstrapToken are both set, they're exchanged
+// for the certificate as an external account binding; otherwise the
+// order is completed with an http-01 challenge served on an ephemeral
+// local listener. verbose controls whether progress is printed, so the
+// background renewal goroutine can run silently.
+func acmeEnroll(ctx context.Context, verbose bool) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	client := &acme.Client{DirectoryURL: flagACMEDirectory, Key: key}
+	if verbose {
+		fmt.Println("enroll: requesting a certificate from", flagACMEDirectory)
+	}
+
+	var cert [][]byte
+	if flagEABKeyID != "" && flagBootstrapToken != "" {
+		cert, err = enrollWithBootstrapToken(ctx, client, flagEABKeyID, flagBootstrapToken)
+	} else {
+		cert, err = enrollWithHTTP01(ctx, client)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := writeKeypairAtomic(key, cert); err != nil {
+		return err
+	}
+	if verbose {
+		fmt.Println("enroll: wrote", filepath.Join(flagHome, "tls.crt"), "and", filepath.Join(flagHome, "tls.key"))
+	}
+	return nil
+}
+
+// enrollWithBootstrapToken registers the ACME account using external
+// account binding (RFC 8555 section 7.3.4): kid is the key ID the CA
+// issued out of band, and macKeyB64 is the base64url-encoded MAC key
+// paired with it. This lets step-ca authenticate the request without an
+// http-01 challenge, so enrollment works even when corectl can't accept
+// an inbound connection (e.g. behind NAT).
+func enrollWithBootstrapToken(ctx context.Context, client *acme.Client, kid, macKeyB64 string) ([][]byte, error) {
+	eab, err := externalAccountBinding(client, kid, macKeyB64)
+	if err != nil {
+		return nil, fmt.Errorf("acme: building external account binding: %w", err)
+	}
+	acct := &acme.Account{ExternalAccountBinding: eab}
+	if _, err := client.Register(ctx, acct, acme.AcceptTOS); err != nil {
+		return nil, fmt.Errorf("acme: registering with bootstrap token: %w", err)
+	}
+
+	dnsName, err := enrollDNSName()
+	if err != nil {
+		return nil, err
+	}
+	order, err := client.AuthorizeOrder(ctx, []acme.AuthzID{{Type: "dns", Value: dnsName}})
+	if err != nil {
+		return nil, fmt.Errorf("acme: creating order: %w", err)
+	}
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, fmt.Errorf("acme: waiting for order: %w", err)
+	}
+
+	csr, err := newCSR(dnsName)
+	if err != nil {
+		return nil, err
+	}
+	cert, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	return cert, err
+}
+
+// externalAccountBinding builds the RFC 8555 section 7.3.4 EAB JWS: a
+// JWS over the account's public key, signed with HMAC-SHA256 using the
+// CA-issued MAC key, with kid identifying which key that is. macKeyB64
+// is base64url-encoded, matching the form every EAB issuer (step-ca
+// included) hands out alongside the key ID.
+func externalAccountBinding(client *acme.Client, kid, macKeyB64 string) ([]byte, error) {
+	macKey, err := base64.RawURLEncoding.DecodeString(macKeyB64)
+	if err != nil {
+		return nil, fmt.Errorf("acme: decoding EAB mac key: %w", err)
+	}
+	jwk, err := accountJWK(client.Key.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.HS256,
+		Key:       macKey,
+	}, (&jose.SignerOptions{}).WithHeader("kid", kid).WithHeader("url", client.DirectoryURL))
+	if err != nil {
+		return nil, err
+	}
+	sig, err := signer.Sign(jwk)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(sig.FullSerialize()), nil
+}
+
+func accountJWK(pub crypto.PublicKey) ([]byte, error) {
+	return json.Marshal(jose.JSONWebKey{Key: pub})
+}
+
+func enrollWithHTTP01(ctx context.Context, client *acme.Client) ([][]byte, error) {
+	if _, err := client.Register(ctx, &acme.Account{}, acme.AcceptTOS); err != nil {
+		return nil, fmt.Errorf("acme: registering account: %w", err)
+	}
+
+	dnsName, err := enrollDNSName()
+	if err != nil {
+		return nil, err
+	}
+	order, err := client.AuthorizeOrder(ctx, []acme.AuthzID{{Type: "dns", Value: dnsName}})
+	if err != nil {
+		return nil, fmt.Errorf("acme: creating order: %w", err)
+	}
+
+	for _, authzURL := range order.AuthzURLs {
+		authz, err := client.GetAuthorization(ctx, authzURL)
+		if err != nil {
+			return nil, err
+		}
+		chal := challengeByType(authz, "http-01")
+		if chal == nil {
+			return nil, fmt.Errorf("acme: no http-01 challenge offered for %s", authzURL)
+		}
+
+		body, err := client.HTTP01ChallengeResponse(chal.Token)
+		if err != nil {
+			return nil, err
+		}
+		ln, err := serveHTTP01Challenge(client.HTTP01ChallengePath(chal.Token), body)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = client.Accept(ctx, chal)
+		ln.Close()
+		if err != nil {
+			return nil, fmt.Errorf("acme: completing http-01 challenge: %w", err)
+		}
+	}
+
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, fmt.Errorf("acme: waiting for order: %w", err)
+	}
+
+	csr, err := newCSR(dnsName)
+	if err != nil {
+		return nil, err
+	}
+	cert, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	return cert, err
+}
+
+func challengeByType(authz *acme.Authorization, typ string) *acme.Challenge {
+	for _, c := range authz.Challenges {
+		if c.Type == typ {
+			return c
+		}
+	}
+	return nil
+}
+
+// serveHTTP01Challenge serves body at path on an ephemeral local
+// listener for the duration of the ACME challenge validation.
+func serveHTTP01Challenge(path, body string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", ":http")
+	if err != nil {
+		return nil, fmt.Errorf("acme: binding http-01 listener: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body)
+	})
+	go http.Serve(ln, mux)
+	return ln, nil
+}
+
+func newCSR(dnsName string) ([]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: dnsName},
+		DNSNames: []string{dnsName},
+	}
+	return x509.CreateCertificateRequest(rand.Reader, tmpl, key)
+}
+
+func writeKeypairAtomic(key *ecdsa.PrivateKey, certDER [][]byte) error {
+	certFile := filepath.Join(flagHome, "tls.crt")
+	keyFile := filepath.Join(flagHome, "tls.key")
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	var certPEM []byte
+	for _, der := range certDER {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+
+	if err := writeFileAtomic(keyFile, keyPEM, 0600); err != nil {
+		return err
+	}
+	return writeFileAtomic(certFile, certPEM, 0600)
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// acmeRevoke revokes the client certificate in $home/tls.crt. RFC 8555
+// section 7.6 allows revocation to be authenticated either by the
+// account that requested the certificate or by the certificate's own
+// key pair; since corectl doesn't persist the account key after
+// enrollment, it authenticates with tls.key, the key it does still have.
+func acmeRevoke(ctx context.Context) error {
+	certFile := filepath.Join(flagHome, "tls.crt")
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return err
+	}
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return fmt.Errorf("acme: %s does not contain a PEM certificate", certFile)
+	}
+
+	keyFile := filepath.Join(flagHome, "tls.key")
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return err
+	}
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return fmt.Errorf("acme: %s does not contain a PEM private key", keyFile)
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return err
+	}
+
+	client := &acme.Client{DirectoryURL: flagACMEDirectory}
+	return client.RevokeCert(ctx, key, certBlock.Bytes, acme.CRLReasonUnspecified)
+}
+
+// startRenewalLoop re-enrolls the TLS client certificate shortly before
+// it expires, for long-running invocations like corectl wait.
+func startRenewalLoop(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Hour):
+				certFile := filepath.Join(flagHome, "tls.crt")
+				if needsEnrollment(certFile) {
+					if err := acmeEnroll(ctx, false); err != nil {
+						fmt.Fprintln(os.Stderr, "auto-tls: background renewal failed:", err)
+					}
+				}
+			}
+		}
+	}()
+}
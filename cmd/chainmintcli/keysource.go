@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/chainmint/core/rpc"
+	"github.com/chainmint/crypto/ed25519/chainkd"
+)
+
+// KeySourceOptions carries the inputs a KeySource may need that are
+// specific to where it stores keys. Each KeySource implementation reads
+// only the field(s) that apply to it.
+type KeySourceOptions struct {
+	// VaultPath names the secret Vault reads or writes. Used by Vault only.
+	VaultPath string
+
+	// MockHSMAlias names the key to create in the core's mockhsm. Used
+	// by MockHSM only.
+	MockHSMAlias string
+
+	// XPrvFile, if set, is a path to an existing xprv to use as the root
+	// key instead of generating one. Used by LocalRand only.
+	XPrvFile string
+}
+
+// KeySource abstracts where corectl gets the root xpub for a new account
+// or asset. LocalRand is the historical behavior (generate an xprv in the
+// corectl process and print it); MockHSM and Vault keep the private key
+// off the operator's terminal entirely.
+type KeySource interface {
+	// NewXPub returns the xpub to register as a root key.
+	NewXPub(ctx context.Context, client *rpc.Client, opts KeySourceOptions) (chainkd.XPub, error)
+}
+
+func keySourceFromFlag(name string) KeySource {
+	switch name {
+	case "", "local":
+		return LocalRand{}
+	case "mockhsm":
+		return MockHSM{}
+	case "vault":
+		return Vault{}
+	}
+	fatalln("error: unknown --key-source", name)
+	return nil
+}
+
+// LocalRand generates an xprv in-process with chainkd.NewXPrv and prints
+// it to stdout, exactly as corectl has always done. Unsafe for production
+// use, but kept as the default for local development. If opts.XPrvFile is
+// set, that xprv is reused instead of generating a new one, so an
+// operator can re-register a key they already hold.
+type LocalRand struct{}
+
+func (LocalRand) NewXPub(ctx context.Context, client *rpc.Client, opts KeySourceOptions) (chainkd.XPub, error) {
+	xprv, err := loadOrGenerateXPrv(opts.XPrvFile)
+	if err != nil {
+		return chainkd.XPub{}, err
+	}
+	fmt.Printf("xprv:%v\n", xprv)
+	fmt.Printf("xpub:%v\n", xprv.XPub())
+	return xprv.XPub(), nil
+}
+
+func loadOrGenerateXPrv(xprvFile string) (chainkd.XPrv, error) {
+	if xprvFile == "" {
+		return chainkd.NewXPrv(nil)
+	}
+	data, err := ioutil.ReadFile(xprvFile)
+	if err != nil {
+		return chainkd.XPrv{}, err
+	}
+	var xprv chainkd.XPrv
+	if err := xprv.UnmarshalText(bytes.TrimSpace(data)); err != nil {
+		return chainkd.XPrv{}, fmt.Errorf("parsing %s: %w", xprvFile, err)
+	}
+	return xprv, nil
+}
+
+// MockHSM asks the target core's mockhsm to generate and hold the key,
+// so the xprv never leaves the core.
+type MockHSM struct{}
+
+func (MockHSM) NewXPub(ctx context.Context, client *rpc.Client, opts KeySourceOptions) (chainkd.XPub, error) {
+	if opts.MockHSMAlias == "" {
+		return chainkd.XPub{}, fmt.Errorf("--mockhsm-alias is required for --key-source=mockhsm")
+	}
+	var resp struct {
+		XPub chainkd.XPub `json:"xpub"`
+	}
+	err := client.Call(ctx, "/mockhsm/create-key", map[string]string{"alias": opts.MockHSMAlias}, &resp)
+	return resp.XPub, err
+}
+
+// Vault fetches or creates a root key in HashiCorp Vault, using a KV v2
+// mount for storage or the Transit backend to generate the key material,
+// depending on what's already present at vaultPath. Only the xpub ever
+// comes back to corectl; VAULT_TOKEN is sent only to VAULT_ADDR.
+type Vault struct{}
+
+func (Vault) NewXPub(ctx context.Context, client *rpc.Client, opts KeySourceOptions) (chainkd.XPub, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	token := os.Getenv("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		return chainkd.XPub{}, fmt.Errorf("VAULT_ADDR and VAULT_TOKEN must be set for --key-source=vault")
+	}
+	if opts.VaultPath == "" {
+		return chainkd.XPub{}, fmt.Errorf("--vault-path is required for --key-source=vault")
+	}
+
+	xpub, err := vaultReadXPub(ctx, addr, token, opts.VaultPath)
+	if err == nil {
+		return xpub, nil
+	}
+	var verr *vaultError
+	if !errors.As(err, &verr) || verr.StatusCode != http.StatusNotFound {
+		return chainkd.XPub{}, fmt.Errorf("vault: reading %s: %w", opts.VaultPath, err)
+	}
+	return vaultGenerateXPub(ctx, addr, token, opts.VaultPath)
+}
+
+func vaultReadXPub(ctx context.Context, addr, token, path string) (chainkd.XPub, error) {
+	var out struct {
+		Data struct {
+			Data struct {
+				XPub chainkd.XPub `json:"xpub"`
+			} `json:"data"`
+		} `json:"data"`
+	}
+	err := vaultRequest(ctx, http.MethodGet, addr, token, "/v1/secret/data/"+path, nil, &out)
+	return out.Data.Data.XPub, err
+}
+
+// vaultGenerateXPub has Vault's Transit backend generate an ed25519
+// keypair under a transit key named after path, so the private key
+// material is created and held inside Vault and never exists in
+// corectl's process. The chaincode is likewise drawn from Vault's
+// /sys/tools/random endpoint rather than a local CSPRNG. Only the
+// resulting xpub is returned to corectl; it is also written to the KV
+// mount, with the transit key's name (for vaultReadXPub to find on a
+// later call).
+func vaultGenerateXPub(ctx context.Context, addr, token, path string) (chainkd.XPub, error) {
+	pub, err := vaultTransitCreateKey(ctx, addr, token, path)
+	if err != nil {
+		return chainkd.XPub{}, err
+	}
+	if len(pub) != 32 {
+		return chainkd.XPub{}, fmt.Errorf("vault transit: expected a 32-byte ed25519 public key, got %d bytes", len(pub))
+	}
+	chainCode, err := vaultRandomBytes(ctx, addr, token, 32)
+	if err != nil {
+		return chainkd.XPub{}, fmt.Errorf("vault: generating chaincode: %w", err)
+	}
+
+	var xpub chainkd.XPub
+	copy(xpub[:32], pub)
+	copy(xpub[32:], chainCode)
+
+	body := map[string]interface{}{
+		"data": map[string]interface{}{
+			"xpub":        xpub.String(),
+			"transit_key": path,
+		},
+	}
+	if err := vaultRequest(ctx, http.MethodPost, addr, token, "/v1/secret/data/"+path, body, nil); err != nil {
+		return chainkd.XPub{}, err
+	}
+	return xpub, nil
+}
+
+// vaultTransitCreateKey creates an ed25519 transit key named name and
+// returns its public key. The private key stays in Vault; it is never
+// exportable.
+func vaultTransitCreateKey(ctx context.Context, addr, token, name string) ([]byte, error) {
+	createBody := map[string]interface{}{"type": "ed25519"}
+	if err := vaultRequest(ctx, http.MethodPost, addr, token, "/v1/transit/keys/"+name, createBody, nil); err != nil {
+		return nil, fmt.Errorf("vault transit: creating key %s: %w", name, err)
+	}
+
+	var out struct {
+		Data struct {
+			Keys map[string]struct {
+				PublicKey string `json:"public_key"`
+			} `json:"keys"`
+			LatestVersion int `json:"latest_version"`
+		} `json:"data"`
+	}
+	if err := vaultRequest(ctx, http.MethodGet, addr, token, "/v1/transit/keys/"+name, nil, &out); err != nil {
+		return nil, fmt.Errorf("vault transit: reading key %s: %w", name, err)
+	}
+	version := strconv.Itoa(out.Data.LatestVersion)
+	key, ok := out.Data.Keys[version]
+	if !ok {
+		return nil, fmt.Errorf("vault transit: no public key for version %s of %s", version, name)
+	}
+	return base64.StdEncoding.DecodeString(key.PublicKey)
+}
+
+// vaultRandomBytes returns n cryptographically random bytes generated by
+// Vault's /sys/tools/random endpoint.
+func vaultRandomBytes(ctx context.Context, addr, token string, n int) ([]byte, error) {
+	var out struct {
+		Data struct {
+			RandomBytes string `json:"random_bytes"`
+		} `json:"data"`
+	}
+	path := fmt.Sprintf("/v1/sys/tools/random/%d", n)
+	if err := vaultRequest(ctx, http.MethodPost, addr, token, path, nil, &out); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(out.Data.RandomBytes)
+}
+
+func vaultRequest(ctx context.Context, method, addr, token, path string, body, out interface{}) error {
+	var reqBody []byte
+	if body != nil {
+		var err error
+		reqBody, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, addr+path, bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return &vaultError{Method: method, Path: path, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// vaultError carries the HTTP status of a failed Vault request, so
+// callers can distinguish "not found" (404, meaning the path is simply
+// unprovisioned yet) from auth/seal/network failures that shouldn't be
+// papered over by minting a brand new key.
+type vaultError struct {
+	Method, Path string
+	StatusCode   int
+	Status       string
+}
+
+func (e *vaultError) Error() string {
+	return fmt.Sprintf("vault: %s %s: %s", e.Method, e.Path, e.Status)
+}
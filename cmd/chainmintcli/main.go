@@ -4,7 +4,8 @@ package main
 import (
 	"bytes"
 	"context"
-	"flag"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net"
@@ -14,13 +15,18 @@ import (
 	"strings"
 	"time"
 
+	"github.com/spf13/cobra"
+	"github.com/spf13/cobra/doc"
+	"golang.org/x/net/http2"
+
 	"github.com/chainmint/core"
 	"github.com/chainmint/core/rpc"
+	"github.com/chainmint/core/rpc/retry"
 	"github.com/chainmint/crypto/ed25519"
+	"github.com/chainmint/crypto/ed25519/chainkd"
 	"github.com/chainmint/env"
 	"github.com/chainmint/errors"
 	"github.com/chainmint/log"
-	"github.com/chainmint/crypto/ed25519/chainkd"
 )
 
 // config vars
@@ -34,123 +40,204 @@ var (
 	buildDate   = "?"
 )
 
+// persistent flags, bound once in rootCmd and read by mustRPCClient
+// (flagJSON by printResponses)
+var (
+	flagHome    string
+	flagURL     string
+	flagTLSCert string
+	flagTLSKey  string
+	flagCACert  string
+	flagSocket  string
+	flagAutoTLS bool
+	flagJSON    bool
+)
+
 // We collect log output in this buffer,
 // and display it only when there's an error.
 var logbuf bytes.Buffer
 
-type command struct {
-	f func(*rpc.Client, []string)
-}
-
 type grantReq struct {
 	Policy    string      `json:"policy"`
 	GuardType string      `json:"guard_type"`
 	GuardData interface{} `json:"guard_data"`
 }
 
-var commands = map[string]*command{
-	"create-block-keypair": {createBlockKeyPair},
-	"reset":                {reset},
-	"grant":                {grant},
-	"revoke":               {revoke},
-	"wait":                 {wait},
-	"create-account":       {createAccount},
-	"update-account-tags":  {updateAccountTags},
-	"create-asset":		{createAsset},
-	"update-asset-tags":	{updateAssetTags},
-	"build-transaction": {buildTransaction},
-}
-
 func main() {
 	log.SetOutput(&logbuf)
 	env.Parse()
 
-	if len(os.Args) >= 2 && os.Args[1] == "-version" {
-		var version string
-		if buildTag != "?" {
-			// build tag with bytom- prefix indicates official release
-			version = strings.TrimPrefix(buildTag, "bytom-")
-		} else {
-			// version of the form rev123 indicates non-release build
-			//version = rev.ID
-		}
-		fmt.Printf("bytomcli %s\n", version)
-		fmt.Printf("build-commit: %v\n", buildCommit)
-		fmt.Printf("build-date: %v\n", buildDate)
-		return
+	if err := rootCmd().Execute(); err != nil {
+		fatalln(err)
 	}
+}
 
-	if len(os.Args) < 2 {
-		help(os.Stdout)
-		os.Exit(0)
+// rootCmd assembles the corectl command tree. mustRPCClient reads
+// flagHome/flagURL/flagTLSCert/flagTLSKey, which are bound here as
+// persistent flags rather than only sourced from env vars.
+func rootCmd() *cobra.Command {
+	root := &cobra.Command{
+		Use:           "corectl",
+		Short:         "corectl controls a Chain Core",
+		SilenceUsage:  true,
+		SilenceErrors: true,
 	}
-	cmd := commands[os.Args[1]]
-	if cmd == nil {
-		fmt.Fprintln(os.Stderr, "unknown command:", os.Args[1])
-		help(os.Stderr)
-		os.Exit(1)
-	}
-	cmd.f(mustRPCClient(), os.Args[2:])
-}
 
+	root.PersistentFlags().StringVar(&flagHome, "home", home, "home directory for TLS cert/key")
+	root.PersistentFlags().StringVar(&flagURL, "url", *coreURL, "URL of the Chain Core ($BYTOM_URL)")
+	root.PersistentFlags().StringVar(&flagTLSCert, "tls-cert", "", "path to TLS client certificate (defaults to $home/tls.crt)")
+	root.PersistentFlags().StringVar(&flagTLSKey, "tls-key", "", "path to TLS client key (defaults to $home/tls.key)")
+	root.PersistentFlags().StringVar(&flagCACert, "ca-cert", "", "path to a CA bundle for verifying the server (used by https+mtls://)")
+	root.PersistentFlags().StringVar(&flagSocket, "socket", "", "unix socket path; shortcut for --url unix://<path>")
+	root.PersistentFlags().BoolVar(&flagAutoTLS, "auto-tls", false, "provision/renew the TLS client cert via ACME if it's missing or near expiry")
+	root.PersistentFlags().BoolVar(&flagJSON, "json", false, "print RPC responses as indented JSON")
+
+	root.AddCommand(
+		versionCmd(),
+		createBlockKeyPairCmd(),
+		resetCmd(),
+		grantCmd(),
+		revokeCmd(),
+		waitCmd(),
+		createAccountCmd(),
+		createAssetCmd(),
+		updateAccountTagsCmd(),
+		updateAssetTagsCmd(),
+		buildTransactionCmd(),
+		rotateKeyCmd(),
+		enrollCmd(),
+		docsCmd(root),
+		completionCmd(root),
+	)
+
+	return root
+}
 
-func createBlockKeyPair(client *rpc.Client, args []string) {
-	if len(args) != 0 {
-		fatalln("error: create-block-keypair takes no args")
+func versionCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "version",
+		Short: "print version information",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fmt.Printf("bytomcli %s\n", strings.TrimPrefix(buildTag, "bytom-"))
+			fmt.Printf("build-commit: %v\n", buildCommit)
+			fmt.Printf("build-date: %v\n", buildDate)
+			return nil
+		},
 	}
-	pub := struct {
-		Pub ed25519.PublicKey
-	}{}
-	err := client.Call(context.Background(), "/mockhsm/create-block-key", nil, &pub)
-	dieOnRPCError(err)
-	fmt.Printf("%x\n", pub.Pub)
 }
 
-// reset will attempt a reset rpc call on a remote core. If the
-// core is not configured with reset capabilities an error is returned.
-func reset(client *rpc.Client, args []string) {
-	if len(args) != 0 {
-		fatalln("error: reset takes no args")
+// docsCmd walks the command tree and emits Markdown or man pages,
+// for publishing alongside corectl releases.
+func docsCmd(root *cobra.Command) *cobra.Command {
+	var dir string
+	var man bool
+	cmd := &cobra.Command{
+		Use:   "docs",
+		Short: "generate corectl documentation",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+			if man {
+				return doc.GenManTree(root, &doc.GenManHeader{Title: "CORECTL", Section: "1"}, dir)
+			}
+			return doc.GenMarkdownTree(root, dir)
+		},
 	}
+	cmd.Flags().StringVar(&dir, "dir", "docs", "output directory")
+	cmd.Flags().BoolVar(&man, "man", false, "generate man pages instead of Markdown")
+	return cmd
+}
 
-	req := map[string]bool{
-		"Everything": true,
+func completionCmd(root *cobra.Command) *cobra.Command {
+	return &cobra.Command{
+		Use:       "completion [bash|zsh|fish]",
+		Short:     "generate a shell completion script",
+		Args:      cobra.ExactValidArgs(1),
+		ValidArgs: []string{"bash", "zsh", "fish"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch args[0] {
+			case "bash":
+				return root.GenBashCompletion(os.Stdout)
+			case "zsh":
+				return root.GenZshCompletion(os.Stdout)
+			default:
+				return root.GenFishCompletion(os.Stdout, true)
+			}
+		},
 	}
-
-	err := client.Call(context.Background(), "/reset", req, nil)
-	dieOnRPCError(err)
 }
 
-func grant(client *rpc.Client, args []string) {
-	editAuthz(client, args, "grant")
+func createBlockKeyPairCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "create-block-keypair",
+		Short: "create a new block signing keypair",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := mustRPCClient()
+			pub := struct {
+				Pub ed25519.PublicKey
+			}{}
+			err := client.Call(context.Background(), "/mockhsm/create-block-key", nil, &pub)
+			dieOnRPCError(err)
+			fmt.Printf("%x\n", pub.Pub)
+			return nil
+		},
+	}
 }
 
-func revoke(client *rpc.Client, args []string) {
-	editAuthz(client, args, "revoke")
+// resetCmd will attempt a reset rpc call on a remote core. If the
+// core is not configured with reset capabilities an error is returned.
+func resetCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "reset",
+		Short: "reset a Chain Core to its initial, empty state",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			req := map[string]bool{"Everything": true}
+			err := mustRPCClient().Call(context.Background(), "/reset", req, nil)
+			dieOnRPCError(err)
+			return nil
+		},
+	}
 }
 
-func editAuthz(client *rpc.Client, args []string, action string) {
-	usage := "usage: corectl " + action + " [policy] [guard]"
-	var flags flag.FlagSet
-
-	flags.Usage = func() {
-		fmt.Fprintln(os.Stderr, usage)
-		fmt.Fprintln(os.Stderr, `
-Where guard is one of:
+const guardHelp = `Where guard is one of:
   token=[id]   to affect an access token
   CN=[name]    to affect an X.509 Common Name
   OU=[name]    to affect an X.509 Organizational Unit
 
-The type of guard (before the = sign) is case-insensitive.
-`)
-		os.Exit(1)
+The type of guard (before the = sign) is case-insensitive.`
+
+func grantCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "grant [policy] [guard]",
+		Short: "grant a policy to a guard",
+		Long:  guardHelp,
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			editAuthz(mustRPCClient(), args, "grant")
+			return nil
+		},
 	}
-	flags.Parse(args)
-	args = flags.Args()
-	if len(args) != 2 {
-		fatalln(usage)
+}
+
+func revokeCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "revoke [policy] [guard]",
+		Short: "revoke a policy from a guard",
+		Long:  guardHelp,
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			editAuthz(mustRPCClient(), args, "revoke")
+			return nil
+		},
 	}
+}
 
+func editAuthz(client *rpc.Client, args []string, action string) {
 	req := grantReq{Policy: args[0]}
 
 	switch typ, data := splitAfter2(args[1], "="); strings.ToUpper(typ) {
@@ -164,8 +251,7 @@ The type of guard (before the = sign) is case-insensitive.
 		req.GuardType = "x509"
 		req.GuardData = map[string]interface{}{"subject": map[string]string{"OU": data}}
 	default:
-		fmt.Fprintln(os.Stderr, "unknown guard type", typ)
-		fatalln(usage)
+		fatalln("unknown guard type", typ)
 	}
 
 	path := map[string]string{
@@ -176,19 +262,370 @@ The type of guard (before the = sign) is case-insensitive.
 	dieOnRPCError(err)
 }
 
+func waitCmd() *cobra.Command {
+	var retryTimeout, initialBackoff, maxBackoff time.Duration
+	var jitter bool
+	var maxAttempts int
+	var quiet bool
+	cmd := &cobra.Command{
+		Use:   "wait",
+		Short: "wait for a Chain Core to be reachable",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			policy := retry.Policy{
+				InitialBackoff: initialBackoff,
+				MaxBackoff:     maxBackoff,
+				Jitter:         jitter,
+				Timeout:        retryTimeout,
+				MaxAttempts:    maxAttempts,
+			}
+			if !quiet {
+				policy.Notify = func(attempt int, err error) {
+					fmt.Fprintf(os.Stderr, "wait: attempt %d failed: %s\n", attempt, err)
+				}
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if flagAutoTLS {
+				startRenewalLoop(ctx)
+			}
+			wait(mustRPCClient(), policy)
+			return nil
+		},
+	}
+	cmd.Flags().DurationVar(&retryTimeout, "retry-timeout", 0, "give up after this much elapsed time (0 = no limit)")
+	cmd.Flags().DurationVar(&initialBackoff, "initial-backoff", 500*time.Millisecond, "backoff after the first failed attempt")
+	cmd.Flags().DurationVar(&maxBackoff, "max-backoff", 30*time.Second, "cap on the backoff between attempts")
+	cmd.Flags().BoolVar(&jitter, "jitter", true, "add uniform random jitter to each backoff")
+	cmd.Flags().IntVar(&maxAttempts, "max-attempts", 0, "give up after this many attempts (0 = no limit)")
+	cmd.Flags().BoolVar(&quiet, "quiet", false, "don't print attempt/error progress to stderr")
+	return cmd
+}
+
+// wait polls /info until it succeeds, the core reports a non-5xx error,
+// or policy's timeout/attempt limit is reached, in which case corectl
+// exits with status 3.
+func wait(client *rpc.Client, policy retry.Policy) {
+	err := policy.Do(context.Background(), func() error {
+		err := client.Call(context.Background(), "/info", nil, nil)
+		if err == nil {
+			return nil
+		}
+		if statusErr, ok := errors.Root(err).(rpc.ErrStatusCode); ok && statusErr.StatusCode/100 != 5 {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		io.Copy(os.Stderr, &logbuf)
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(3)
+	}
+}
+
+func createAccountCmd() *cobra.Command {
+	var alias, clientToken, keySource, vaultPath, mockhsmAlias, xprvFile string
+	var quorum int
+	var tags []string
+	cmd := &cobra.Command{
+		Use:   "create-account",
+		Short: "create a new account",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts := KeySourceOptions{VaultPath: vaultPath, MockHSMAlias: mockhsmAlias, XPrvFile: xprvFile}
+			createAccount(mustRPCClient(), alias, quorum, clientToken, parseTags(tags), keySourceFromFlag(keySource), opts)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&alias, "alias", "aa", "account alias")
+	cmd.Flags().IntVar(&quorum, "quorum", 1, "signing quorum")
+	cmd.Flags().StringSliceVar(&tags, "tags", []string{"test_tag=v0"}, "key=value tag pairs")
+	cmd.Flags().StringVar(&clientToken, "client-token", "", "idempotency token for the create-account request")
+	cmd.Flags().StringVar(&keySource, "key-source", "local", "where to get the root xpub: local, mockhsm, or vault")
+	cmd.Flags().StringVar(&vaultPath, "vault-path", "", "Vault secret path for --key-source=vault")
+	cmd.Flags().StringVar(&mockhsmAlias, "mockhsm-alias", "", "mockhsm key alias for --key-source=mockhsm")
+	cmd.Flags().StringVar(&xprvFile, "xprv-file", "", "path to an existing xprv to use as the root key, instead of generating one (--key-source=local only)")
+	return cmd
+}
+
+func createAccount(client *rpc.Client, alias string, quorum int, clientToken string, tags map[string]interface{}, keySource KeySource, opts KeySourceOptions) {
+	xpub, err := keySource.NewXPub(context.Background(), client, opts)
+	if err != nil {
+		fatalln("error: generating root xpub:", err)
+	}
+	type Ins struct {
+		RootXPubs   []chainkd.XPub `json:"root_xpubs"`
+		Quorum      int
+		Alias       string
+		Tags        map[string]interface{}
+		ClientToken string `json:"client_token"`
+	}
+	ins := Ins{
+		RootXPubs:   []chainkd.XPub{xpub},
+		Quorum:      quorum,
+		Alias:       alias,
+		Tags:        tags,
+		ClientToken: clientToken,
+	}
+	responses := make([]interface{}, 50)
+	client.Call(context.Background(), "/create-account", &[]Ins{ins}, &responses)
+	printResponses(responses)
+}
+
+func createAssetCmd() *cobra.Command {
+	var alias, clientToken, definition, keySource, vaultPath, mockhsmAlias, xprvFile string
+	var quorum int
+	var tags []string
+	cmd := &cobra.Command{
+		Use:   "create-asset",
+		Short: "create a new asset",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts := KeySourceOptions{VaultPath: vaultPath, MockHSMAlias: mockhsmAlias, XPrvFile: xprvFile}
+			createAsset(mustRPCClient(), alias, quorum, clientToken, parseTags(tags), parseDefinition(definition), keySourceFromFlag(keySource), opts)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&alias, "alias", "aa", "asset alias")
+	cmd.Flags().IntVar(&quorum, "quorum", 1, "signing quorum")
+	cmd.Flags().StringSliceVar(&tags, "tags", []string{"test_tag=v0"}, "key=value tag pairs")
+	cmd.Flags().StringVar(&definition, "definition", "test_definition=v0", "key=value definition pair")
+	cmd.Flags().StringVar(&clientToken, "client-token", "", "idempotency token for the create-asset request")
+	cmd.Flags().StringVar(&keySource, "key-source", "local", "where to get the root xpub: local, mockhsm, or vault")
+	cmd.Flags().StringVar(&vaultPath, "vault-path", "", "Vault secret path for --key-source=vault")
+	cmd.Flags().StringVar(&mockhsmAlias, "mockhsm-alias", "", "mockhsm key alias for --key-source=mockhsm")
+	cmd.Flags().StringVar(&xprvFile, "xprv-file", "", "path to an existing xprv to use as the root key, instead of generating one (--key-source=local only)")
+	return cmd
+}
+
+func createAsset(client *rpc.Client, alias string, quorum int, clientToken string, tags, definition map[string]interface{}, keySource KeySource, opts KeySourceOptions) {
+	xpub, err := keySource.NewXPub(context.Background(), client, opts)
+	if err != nil {
+		fatalln("error: generating root xpub:", err)
+	}
+	type Ins struct {
+		RootXPubs   []chainkd.XPub `json:"root_xpubs"`
+		Quorum      int
+		Alias       string
+		Tags        map[string]interface{}
+		Definition  map[string]interface{}
+		ClientToken string `json:"client_token"`
+	}
+	ins := Ins{
+		RootXPubs:   []chainkd.XPub{xpub},
+		Quorum:      quorum,
+		Alias:       alias,
+		Tags:        tags,
+		Definition:  definition,
+		ClientToken: clientToken,
+	}
+	responses := make([]interface{}, 50)
+	client.Call(context.Background(), "/create-asset", &[]Ins{ins}, &responses)
+	printResponses(responses)
+}
+
+func updateAccountTagsCmd() *cobra.Command {
+	var id, alias string
+	var tags []string
+	cmd := &cobra.Command{
+		Use:   "update-account-tags",
+		Short: "update the tags on an account",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			updateAccountTags(mustRPCClient(), id, alias, parseTags(tags))
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&id, "id", "1234", "account ID")
+	cmd.Flags().StringVar(&alias, "alias", "asdfg", "account alias")
+	cmd.Flags().StringSliceVar(&tags, "tags", []string{"test_tag=v0"}, "key=value tag pairs")
+	return cmd
+}
+
+func updateAccountTags(client *rpc.Client, id, alias string, tags map[string]interface{}) {
+	type Ins struct {
+		ID    *string
+		Alias *string
+		Tags  map[string]interface{} `json:"tags"`
+	}
+	ins := Ins{ID: &id, Alias: &alias, Tags: tags}
+	responses := make([]interface{}, 50)
+	client.Call(context.Background(), "/update-account-tags", &[]Ins{ins}, &responses)
+	printResponses(responses)
+}
+
+func updateAssetTagsCmd() *cobra.Command {
+	var id, alias string
+	var tags []string
+	cmd := &cobra.Command{
+		Use:   "update-asset-tags",
+		Short: "update the tags on an asset",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			updateAssetTags(mustRPCClient(), id, alias, parseTags(tags))
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&id, "id", "123456", "asset ID")
+	cmd.Flags().StringVar(&alias, "alias", "asdfg", "asset alias")
+	cmd.Flags().StringSliceVar(&tags, "tags", []string{"test_tag=v0"}, "key=value tag pairs")
+	return cmd
+}
+
+func updateAssetTags(client *rpc.Client, id, alias string, tags map[string]interface{}) {
+	type Ins struct {
+		ID    *string
+		Alias *string
+		Tags  map[string]interface{} `json:"tags"`
+	}
+	ins := Ins{ID: &id, Alias: &alias, Tags: tags}
+	responses := make([]interface{}, 50)
+	client.Call(context.Background(), "/update-asset-tags", &[]Ins{ins}, &responses)
+	printResponses(responses)
+}
+
+// rotateKeyCmd replaces an account's root xpub with a freshly generated
+// one from the chosen KeySource, so an operator can rotate a key without
+// ever having the old or new xprv pass through their terminal (unless
+// --key-source=local, which still prints it as createAccount does).
+func rotateKeyCmd() *cobra.Command {
+	var id, alias, keySource, vaultPath, mockhsmAlias string
+	cmd := &cobra.Command{
+		Use:   "rotate-key",
+		Short: "replace an account's root xpub with a newly generated key",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts := KeySourceOptions{VaultPath: vaultPath, MockHSMAlias: mockhsmAlias}
+			rotateKey(mustRPCClient(), id, alias, keySourceFromFlag(keySource), opts)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&id, "id", "", "account ID")
+	cmd.Flags().StringVar(&alias, "alias", "", "account alias")
+	cmd.Flags().StringVar(&keySource, "key-source", "local", "where to get the new root xpub: local, mockhsm, or vault")
+	cmd.Flags().StringVar(&vaultPath, "vault-path", "", "Vault secret path for --key-source=vault")
+	cmd.Flags().StringVar(&mockhsmAlias, "mockhsm-alias", "", "mockhsm key alias for --key-source=mockhsm")
+	return cmd
+}
+
+func rotateKey(client *rpc.Client, id, alias string, keySource KeySource, opts KeySourceOptions) {
+	if id == "" && alias == "" {
+		fatalln("error: rotate-key requires --id or --alias")
+	}
+	xpub, err := keySource.NewXPub(context.Background(), client, opts)
+	if err != nil {
+		fatalln("error: generating root xpub:", err)
+	}
+
+	type Ins struct {
+		ID       *string      `json:"id,omitempty"`
+		Alias    *string      `json:"alias,omitempty"`
+		RootXPub chainkd.XPub `json:"root_xpub"`
+	}
+	ins := Ins{RootXPub: xpub}
+	if id != "" {
+		ins.ID = &id
+	}
+	if alias != "" {
+		ins.Alias = &alias
+	}
+	var response interface{}
+	err = client.Call(context.Background(), "/update-account-xpub", ins, &response)
+	dieOnRPCError(err)
+	fmt.Printf("response:%v\n", response)
+}
+
+func buildTransactionCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "build-transaction",
+		Short: "build an unsigned transaction template",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			buildTransaction(mustRPCClient())
+			return nil
+		},
+	}
+}
+
+func buildTransaction(client *rpc.Client) {
+}
+
+// parseTags turns "key=value" pairs into the tags map shape the
+// /create-account, /create-asset, and /update-*-tags RPCs expect.
+func parseTags(pairs []string) map[string]interface{} {
+	tags := make(map[string]interface{}, len(pairs))
+	for _, pair := range pairs {
+		k, v := splitAfter2(pair, "=")
+		tags[strings.TrimSuffix(k, "=")] = v
+	}
+	return tags
+}
+
+// parseDefinition turns a single "key=value" pair into an asset definition map.
+func parseDefinition(pair string) map[string]interface{} {
+	k, v := splitAfter2(pair, "=")
+	return map[string]interface{}{strings.TrimSuffix(k, "="): v}
+}
+
+// printResponses prints the result of a batch RPC call, as indented JSON
+// when --json is set, or in the historical Go %v form otherwise.
+func printResponses(responses interface{}) {
+	if !flagJSON {
+		fmt.Printf("responses:%v\n", responses)
+		return
+	}
+	out, err := json.MarshalIndent(responses, "", "  ")
+	if err != nil {
+		fatalln("error: marshaling responses:", err)
+	}
+	fmt.Println(string(out))
+}
+
 func mustRPCClient() *rpc.Client {
 	// TODO(kr): refactor some of this cert-loading logic into bytom/blockchain
 	// and use it from cored as well.
 	// Note that this function, unlike maybeUseTLS in cored,
 	// does not load the cert and key from env vars,
-	// only from the filesystem.
-	certFile := filepath.Join(home, "tls.crt")
-	keyFile := filepath.Join(home, "tls.key")
-	config, err := core.TLSConfig(certFile, keyFile, "")
-	if err == core.ErrNoTLS {
-		return &rpc.Client{BaseURL: *coreURL}
-	} else if err != nil {
-		fatalln("error: loading TLS cert:", err)
+	// only from the filesystem (or the --tls-cert/--tls-key flags).
+	rawURL := flagURL
+	if flagSocket != "" {
+		rawURL = "unix://" + flagSocket
+	}
+
+	if scheme, rest, ok := strings.Cut(rawURL, "://"); ok {
+		switch scheme {
+		case "unix":
+			return unixSocketClient(rest)
+		case "h2c":
+			return h2cClient(rest)
+		case "https+mtls":
+			return mtlsClient(rest)
+		}
+	}
+
+	certFile := flagTLSCert
+	if certFile == "" {
+		certFile = filepath.Join(flagHome, "tls.crt")
+	}
+	keyFile := flagTLSKey
+	if keyFile == "" {
+		keyFile = filepath.Join(flagHome, "tls.key")
+	}
+
+	var config *tls.Config
+	var err error
+	if flagAutoTLS {
+		config, err = autoTLSConfig(context.Background(), certFile, keyFile)
+		if err != nil {
+			fatalln("error: auto-tls:", err)
+		}
+	} else {
+		config, err = core.TLSConfig(certFile, keyFile, flagCACert)
+		if err == core.ErrNoTLS {
+			return &rpc.Client{BaseURL: rawURL}
+		} else if err != nil {
+			fatalln("error: loading TLS cert:", err)
+		}
 	}
 
 	t := &http.Transport{
@@ -204,7 +641,7 @@ func mustRPCClient() *rpc.Client {
 		ExpectContinueTimeout: 1 * time.Second,
 	}
 
-	url := *coreURL
+	url := rawURL
 	if strings.HasPrefix(url, "http:") {
 		url = "https:" + url[5:]
 	}
@@ -215,6 +652,69 @@ func mustRPCClient() *rpc.Client {
 	}
 }
 
+// unixSocketClient dials an AF_UNIX socket (e.g. --socket /var/run/chain/core.sock
+// or --url unix:///var/run/chain/core.sock) instead of a TCP address, so corectl
+// can reach a locally-sandboxed cored without exposing a TCP port.
+func unixSocketClient(sockPath string) *rpc.Client {
+	sockPath = strings.TrimPrefix(sockPath, "//")
+	t := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", sockPath)
+		},
+	}
+	return &rpc.Client{
+		BaseURL: "http://unix",
+		Client:  &http.Client{Transport: t},
+	}
+}
+
+// h2cClient speaks cleartext HTTP/2 (RFC 7540 prior-knowledge), for
+// clustered deployments fronted by an h2c-capable proxy.
+func h2cClient(hostPort string) *rpc.Client {
+	t := &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}
+	return &rpc.Client{
+		BaseURL: "http://" + strings.TrimPrefix(hostPort, "//"),
+		Client:  &http.Client{Transport: t},
+	}
+}
+
+// mtlsClient requires the caller to present a client certificate and
+// verifies the server against --ca-cert, for clusters that require
+// mutual TLS between corectl and cored.
+func mtlsClient(hostPort string) *rpc.Client {
+	certFile := flagTLSCert
+	if certFile == "" {
+		certFile = filepath.Join(flagHome, "tls.crt")
+	}
+	keyFile := flagTLSKey
+	if keyFile == "" {
+		keyFile = filepath.Join(flagHome, "tls.key")
+	}
+	if flagCACert == "" {
+		fatalln("error: https+mtls:// requires --ca-cert")
+	}
+
+	config, err := core.TLSConfig(certFile, keyFile, flagCACert)
+	if err != nil {
+		fatalln("error: loading TLS cert:", err)
+	}
+
+	t := &http.Transport{
+		TLSClientConfig:     config,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	return &rpc.Client{
+		BaseURL: "https://" + strings.TrimPrefix(hostPort, "//"),
+		Client:  &http.Client{Transport: t},
+	}
+}
+
 func fatalln(v ...interface{}) {
 	io.Copy(os.Stderr, &logbuf)
 	fmt.Fprintln(os.Stderr, v...)
@@ -244,17 +744,6 @@ func dieOnRPCError(err error, prefixes ...interface{}) {
 	os.Exit(2)
 }
 
-func help(w io.Writer) {
-	fmt.Fprintln(w, "usage: corectl [-version] [command] [arguments]")
-	fmt.Fprint(w, "\nThe commands are:\n\n")
-	for name := range commands {
-		fmt.Fprintln(w, "\t", name)
-	}
-	fmt.Fprint(w, "\nFlags:\n")
-	fmt.Fprintln(w, "\t-version   print version information")
-	fmt.Fprintln(w)
-}
-
 // splitAfter2 is like strings.SplitAfterN with n=2.
 // If sep is not in s, it returns a="" and b=s.
 func splitAfter2(s, sep string) (a, b string) {
@@ -262,130 +751,3 @@ func splitAfter2(s, sep string) (a, b string) {
 	k := i + len(sep)
 	return s[:k], s[k:]
 }
-
-func wait(client *rpc.Client, args []string) {
-	if len(args) != 0 {
-		fatalln("error: wait takes no args")
-	}
-
-	for {
-		err := client.Call(context.Background(), "/info", nil, nil)
-		if err == nil {
-			break
-		}
-
-		if statusErr, ok := errors.Root(err).(rpc.ErrStatusCode); ok && statusErr.StatusCode/100 != 5 {
-			break
-		}
-
-		time.Sleep(500 * time.Millisecond)
-	}
-}
-
-func createAccount(client *rpc.Client, args []string) {
-	if len(args) != 1 {
-		fatalln("error: createAccount takes no args")
-	}
-	xprv, err := chainkd.NewXPrv(nil)
-	if err != nil {
-		fatalln("NewXprv error.")
-	}
-	xpub := xprv.XPub()
-	fmt.Printf("xprv:%v\n", xprv)
-	fmt.Printf("xpub:%v\n", xpub)
-	type Ins struct {
-	    RootXPubs []chainkd.XPub `json:"root_xpubs"`
-		Quorum    int
-		Alias     string
-		Tags      map[string]interface{}
-		ClientToken string `json:"client_token"`
-	}
-	var ins Ins
-	ins.RootXPubs = []chainkd.XPub{xpub}
-	ins.Quorum = 1
-	ins.Alias = "aa"
-	ins.Tags = map[string]interface{}{"test_tag": "v0",}
-	ins.ClientToken = args[0]
-	responses := make([]interface{}, 50)
-	client.Call(context.Background(), "/create-account", &[]Ins{ins,}, &responses)
-	//dieOnRPCError(err)
-	fmt.Printf("responses:%v\n", responses)
-}
-
-func createAsset(client *rpc.Client, args []string) {
-	if len(args) != 1 {
-		fatalln("error: createAsset takes no args")
-	}
-	xprv, err := chainkd.NewXPrv(nil)
-	if err != nil {
-		fatalln("NewXprv error.")
-	}
-	xpub := xprv.XPub()
-	fmt.Printf("xprv:%v\n", xprv)
-	fmt.Printf("xpub:%v\n", xpub)
-	type Ins struct {
-	    RootXPubs []chainkd.XPub `json:"root_xpubs"`
-		Quorum    int
-		Alias     string
-		Tags      map[string]interface{}
-		Definition  map[string]interface{}
-		ClientToken string `json:"client_token"`
-	}
-	var ins Ins
-	ins.RootXPubs = []chainkd.XPub{xpub}
-	ins.Quorum = 1
-	ins.Alias = "aa"
-	ins.Tags = map[string]interface{}{"test_tag": "v0",}
-	ins.Definition = map[string]interface{}{"test_definition": "v0"}
-	ins.ClientToken = args[0]
-	responses := make([]interface{}, 50)
-	client.Call(context.Background(), "/create-asset", &[]Ins{ins,}, &responses)
-	//dieOnRPCError(err)
-	fmt.Printf("responses:%v\n", responses)
-}
-
-func updateAccountTags(client *rpc.Client,args []string){
-	if len(args) != 0{
-		fatalln("error:updateAccountTags not use args")
-	}
-	type Ins struct {
-	ID    *string
-	Alias *string
-	Tags  map[string]interface{} `json:"tags"`
-}
-	var ins Ins
-	aa := "1234"
-	alias := "asdfg"
-	ins.ID = &aa
-	ins.Alias = &alias
-	ins.Tags = map[string]interface{}{"test_tag": "v0",}
-	responses := make([]interface{}, 50)
-	client.Call(context.Background(), "/update-account-tags", &[]Ins{ins,}, &responses)
-	fmt.Printf("responses:%v\n", responses)
-}
-
-func updateAssetTags(client *rpc.Client, args []string){
-	if len(args) != 0{
-			fatalln("error:updateAccountTags not use args")
-	}
-	type Ins struct {
-	ID    *string
-	Alias *string
-	Tags  map[string]interface{} `json:"tags"`
-	}
-	var ins Ins
-	id := "123456"
-	alias := "asdfg"
-	ins.ID = &id
-	ins.Alias = &alias
-	ins.Tags = map[string]interface{}{"test_tag": "v0",}
-	responses := make([]interface{}, 50)
-	client.Call(context.Background(), "/update-asset-tags", &[]Ins{ins,}, &responses)
-	fmt.Printf("responses:%v\n", responses)
-}
-
-func buildTransaction(client *rpc.Client, args []string) {
-	if len(args) != 0 {
-		fatalln("error:updateAccountTags not use args")
-	}
-}
@@ -0,0 +1,107 @@
+// Package retry implements an exponential-backoff retry loop for RPC
+// calls that may fail transiently, such as waiting for a Chain Core to
+// come up. It is shared by corectl's wait command and is meant to be
+// reused by other commands (and by dieOnRPCError-style callers) that
+// need the same give-up-after-a-deadline behavior.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// ErrTimeout is returned when the elapsed time exceeds Policy.Timeout.
+var ErrTimeout = errors.New("retry: timeout exceeded")
+
+// ErrMaxAttempts is returned when the number of attempts reaches Policy.MaxAttempts.
+var ErrMaxAttempts = errors.New("retry: max attempts exceeded")
+
+// Policy configures a Do loop's backoff, jitter, and give-up conditions.
+type Policy struct {
+	// InitialBackoff is the sleep duration after the first failed attempt.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the computed backoff; it never grows past this value.
+	// With Jitter set, the sleep itself can exceed it by up to half.
+	MaxBackoff time.Duration
+
+	// Jitter, when true, adds a uniform random delay in [0, backoff/2]
+	// on top of each computed backoff, to avoid thundering-herd retries.
+	Jitter bool
+
+	// Timeout is the total elapsed time, across all attempts, after
+	// which Do gives up and returns ErrTimeout. Zero means no limit.
+	Timeout time.Duration
+
+	// MaxAttempts is the number of calls to fn after which Do gives up
+	// and returns ErrMaxAttempts. Zero means no limit.
+	MaxAttempts int
+
+	// Notify, if non-nil, is called after each failed attempt with the
+	// attempt number (starting at 1) and the error it returned, before
+	// Do sleeps. Callers use this to log progress to stderr.
+	Notify func(attempt int, err error)
+}
+
+// Do calls fn until it returns nil, or until the policy's timeout or
+// max-attempt limit is reached, sleeping with exponential backoff
+// between attempts.
+func (p Policy) Do(ctx context.Context, fn func() error) error {
+	start := time.Now()
+	backoff := p.InitialBackoff
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		if p.Notify != nil {
+			p.Notify(attempt, err)
+		}
+
+		if p.MaxAttempts > 0 && attempt >= p.MaxAttempts {
+			return fmt.Errorf("%w: %s", ErrMaxAttempts, err)
+		}
+		if p.Timeout > 0 && time.Since(start) >= p.Timeout {
+			return fmt.Errorf("%w: %s", ErrTimeout, err)
+		}
+
+		sleep := backoff
+		if sleep > p.MaxBackoff {
+			sleep = p.MaxBackoff
+		}
+		if p.Jitter {
+			sleep = addJitter(sleep)
+		}
+		if p.Timeout > 0 {
+			if remaining := p.Timeout - time.Since(start); sleep > remaining {
+				sleep = remaining
+			}
+		}
+
+		select {
+		case <-time.After(sleep):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		// Stop doubling once the cap is reached, so backoff cannot overflow.
+		if backoff < p.MaxBackoff {
+			backoff *= 2
+		}
+	}
+}
+
+// addJitter adds a uniform random delay in [0, d/2] on top of d. Split
+// out of Do so the jitter math can be tested without relying on
+// wall-clock measurements of an actual sleep.
+func addJitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return d
+	}
+	return d + time.Duration(rand.Int63n(int64(d)/2+1))
+}
@@ -0,0 +1,132 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var errTransient = errors.New("transient failure")
+
+func TestDoSucceedsWithoutRetrying(t *testing.T) {
+	calls := 0
+	err := Policy{InitialBackoff: time.Millisecond}.Do(context.Background(), func() error {
+		calls++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Do returned %v, want nil", err)
+	}
+	if calls != 1 {
+		t.Fatalf("fn called %d times, want 1", calls)
+	}
+}
+
+func TestDoSucceedsAfterTransientFailures(t *testing.T) {
+	calls := 0
+	err := Policy{InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}.Do(context.Background(), func() error {
+		calls++
+		if calls < 3 {
+			return errTransient
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Do returned %v, want nil", err)
+	}
+	if calls != 3 {
+		t.Fatalf("fn called %d times, want 3", calls)
+	}
+}
+
+func TestDoReturnsErrMaxAttempts(t *testing.T) {
+	calls := 0
+	p := Policy{InitialBackoff: time.Millisecond, MaxAttempts: 3}
+	err := p.Do(context.Background(), func() error {
+		calls++
+		return errTransient
+	})
+	if !errors.Is(err, ErrMaxAttempts) {
+		t.Fatalf("Do returned %v, want ErrMaxAttempts", err)
+	}
+	if calls != 3 {
+		t.Fatalf("fn called %d times, want 3", calls)
+	}
+}
+
+func TestDoReturnsErrTimeout(t *testing.T) {
+	p := Policy{InitialBackoff: 5 * time.Millisecond, MaxBackoff: 5 * time.Millisecond, Timeout: 20 * time.Millisecond}
+	start := time.Now()
+	err := p.Do(context.Background(), func() error {
+		return errTransient
+	})
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Do returned %v, want ErrTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Do took %s, want well under its 20ms timeout plus slack", elapsed)
+	}
+}
+
+func TestDoCapsBackoffAtMaxBackoff(t *testing.T) {
+	var sleeps []time.Duration
+	last := time.Now()
+	p := Policy{
+		InitialBackoff: 2 * time.Millisecond,
+		MaxBackoff:     6 * time.Millisecond,
+		MaxAttempts:    7,
+		Notify: func(attempt int, err error) {
+			now := time.Now()
+			sleeps = append(sleeps, now.Sub(last))
+			last = now
+		},
+	}
+	err := p.Do(context.Background(), func() error { return errTransient })
+	if !errors.Is(err, ErrMaxAttempts) {
+		t.Fatalf("Do returned %v, want ErrMaxAttempts", err)
+	}
+
+	// The first Notify fires right after the first failed call, before any
+	// sleep; every gap after that should be capped near MaxBackoff, not
+	// keep doubling toward InitialBackoff*2^n.
+	const slack = 20 * time.Millisecond
+	for i := 1; i < len(sleeps); i++ {
+		if sleeps[i] > p.MaxBackoff+slack {
+			t.Fatalf("gap %d was %s, want <= MaxBackoff (%s) plus slack", i, sleeps[i], p.MaxBackoff)
+		}
+	}
+}
+
+// TestAddJitterStaysWithinBounds exercises the jitter math directly
+// rather than timing an actual sleep, which flaked under scheduling
+// noise (a loaded CI box can blow well past a wall-clock upper bound
+// with no bug involved).
+func TestAddJitterStaysWithinBounds(t *testing.T) {
+	const backoff = 10 * time.Millisecond
+	for i := 0; i < 1000; i++ {
+		got := addJitter(backoff)
+		if got < backoff || got > backoff+backoff/2 {
+			t.Fatalf("addJitter(%s) = %s, want within [%s, %s]", backoff, got, backoff, backoff+backoff/2)
+		}
+	}
+}
+
+func TestAddJitterNonPositive(t *testing.T) {
+	if got := addJitter(0); got != 0 {
+		t.Fatalf("addJitter(0) = %s, want 0", got)
+	}
+}
+
+func TestDoRespectsContextCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := Policy{InitialBackoff: time.Hour}
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		cancel()
+	}()
+	err := p.Do(ctx, func() error { return errTransient })
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Do returned %v, want context.Canceled", err)
+	}
+}